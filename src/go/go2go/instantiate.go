@@ -9,6 +9,8 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
+	"io"
+	"sort"
 )
 
 // typeArgs holds type arguments for the function that we are instantiating.
@@ -19,6 +21,42 @@ type typeArgs struct {
 	info  *types.Info  // info for package of function being instantiated
 	toAST map[types.Object]ast.Expr
 	toTyp map[*types.TypeParam]types.Type
+
+	// dictParams maps a type parameter to the identifier of the
+	// dictionary parameter describing it, for a ModeDict instantiation.
+	// It is nil outside of ModeDict.
+	dictParams map[*types.TypeParam]*ast.Ident
+
+	// typeCache memoizes instantiateTypeMemo by typ's identity, so that
+	// a types.Type which refers back to itself (for example the struct
+	// underlying a recursive generic type) does not send instantiateType
+	// into unbounded recursion.
+	typeCache map[types.Type]types.Type
+}
+
+// dictParam returns the dictionary parameter identifier for tp, and
+// reports whether one exists (it only does in ModeDict).
+func (ta *typeArgs) dictParam(tp *types.TypeParam) (*ast.Ident, bool) {
+	id, ok := ta.dictParams[tp]
+	return id, ok
+}
+
+// dictEqualIdent reports whether e's static type, in the uninstantiated
+// generic body, is a type parameter we are erasing in ModeDict, and if
+// so returns the identifier of the dictionary that describes it.
+func (ta *typeArgs) dictEqualIdent(e ast.Expr) (*ast.Ident, bool) {
+	if ta.dictParams == nil {
+		return nil, false
+	}
+	tv, ok := ta.info.Types[e]
+	if !ok {
+		return nil, false
+	}
+	tp, ok := tv.Type.(*types.TypeParam)
+	if !ok {
+		return nil, false
+	}
+	return ta.dictParam(tp)
 }
 
 // newTypeArgs returns a new typeArgs value.
@@ -95,8 +133,516 @@ func (ta *typeArgs) typ(param *types.TypeParam) (types.Type, bool) {
 	return t, ok
 }
 
-// instantiateFunction creates a new instantiation of a function.
+// instantiation records a previously emitted instantiation of a generic
+// function or type declaration, so that a later request for the same
+// qid with identical type arguments can reuse it instead of walking the
+// AST again.
+type instantiation struct {
+	types []types.Type // the type arguments this instantiation was built for
+	ident *ast.Ident   // the identifier of the emitted declaration
+	typ   types.Type   // the instantiated type, for a type declaration
+}
+
+// sameTypeArgs reports whether a and b are the same length and every
+// element is types.Identical.
+func sameTypeArgs(a, b []types.Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, at := range a {
+		if !types.Identical(at, b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoizeType looks up typ in cache, and if absent records a provisional
+// self-mapping (typ maps to itself) before calling compute, replacing it
+// with compute's result once compute returns. The provisional entry is
+// what a compute that refers back to typ (directly or through mutual
+// recursion) finds on a re-entrant lookup, so a self-referential type
+// graph terminates instead of recursing forever.
+func memoizeType(cache map[types.Type]types.Type, typ types.Type, compute func() types.Type) types.Type {
+	if cached, ok := cache[typ]; ok {
+		return cached
+	}
+	cache[typ] = typ
+	result := compute()
+	cache[typ] = result
+	return result
+}
+
+// instantiateTypeMemo wraps t.instantiateType with memoization keyed on
+// typ's identity within ta. instantiateTypeDecl already protects against
+// a named generic type recursing into itself (e.g. the *Tree[T] field
+// of type Tree[T] struct { L, R *Tree[T]; V T }) by caching the
+// instantiation before walking its definition; this does the same for
+// instantiateType's own types.Type-level walk, so that a type reached
+// without going back through instantiateTypeDecl still terminates.
+func (t *translator) instantiateTypeMemo(ta *typeArgs, typ types.Type) types.Type {
+	if ta.typeCache == nil {
+		ta.typeCache = make(map[types.Type]types.Type)
+	}
+	return memoizeType(ta.typeCache, typ, func() types.Type {
+		return t.instantiateType(ta, typ)
+	})
+}
+
+// lookupInstantiation looks for an existing instantiation of qid with
+// type arguments identical to typeTypes. It reports whether one was
+// found.
+func (t *translator) lookupInstantiation(qid qualifiedIdent, typeTypes []types.Type) (*instantiation, bool) {
+	for _, inst := range t.instantiations[qid] {
+		if sameTypeArgs(inst.types, typeTypes) {
+			return inst, true
+		}
+	}
+	return nil, false
+}
+
+// addInstantiation records a new instantiation of qid, so that later
+// lookups with the same type arguments can reuse it. It is called
+// before the declaration's body is walked, so that a generic type or
+// function that refers to itself recursively (with the same type
+// arguments) terminates rather than looping forever.
+func (t *translator) addInstantiation(qid qualifiedIdent, typeTypes []types.Type, ident *ast.Ident) *instantiation {
+	if t.instantiations == nil {
+		t.instantiations = make(map[qualifiedIdent][]*instantiation)
+	}
+	inst := &instantiation{types: typeTypes, ident: ident}
+	t.instantiations[qid] = append(t.instantiations[qid], inst)
+	return inst
+}
+
+// Mode selects the code-generation strategy instantiateFunction uses
+// for a generic function.
+type Mode int
+
+const (
+	// ModeMonomorphize emits a distinct, fully specialized FuncDecl for
+	// every distinct tuple of type arguments a generic function is
+	// called with. This is the default.
+	ModeMonomorphize Mode = iota
+
+	// ModeDict emits a single non-generic FuncDecl per distinct GC shape
+	// a generic function is called with, taking an extra dictionary
+	// parameter per type parameter that supplies the operations
+	// (equality, hashing, size) the body needs on values of the erased
+	// type. This trades an indirection through the dictionary for much
+	// less generated code than ModeMonomorphize.
+	ModeDict
+)
+
+// gcShape classifies typ for the purpose of dictionary sharing: two
+// type arguments with the same shape can share one ModeDict
+// specialization, because the generated body only ever sees them
+// through unsafe.Pointer and the dictionary.
+func gcShape(typ types.Type) string {
+	switch u := typ.Underlying().(type) {
+	case *types.Pointer, *types.Signature, *types.Map, *types.Chan:
+		// All pointer-shaped: a single machine word, copied and compared
+		// like any other pointer.
+		return "ptr"
+	case *types.Interface:
+		return "iface"
+	case *types.Basic:
+		return "basic:" + u.String()
+	default:
+		// Structs, arrays, and other value types are not, in general,
+		// layout-compatible just because they have the same size; fall
+		// back to one shape per distinct type rather than guessing.
+		return "named:" + types.TypeString(typ, nil)
+	}
+}
+
+// dictInstantiation records a ModeDict specialization shared by every
+// type-argument tuple with the same GC-shape tuple.
+type dictInstantiation struct {
+	shapes []string
+	ident  *ast.Ident
+}
+
+// sameShapes reports whether a and b are the same GC-shape tuple.
+func sameShapes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, s := range a {
+		if s != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupDictInstantiation looks for a previous ModeDict specialization
+// of qid built for the same GC-shape tuple as shapes.
+func (t *translator) lookupDictInstantiation(qid qualifiedIdent, shapes []string) (*ast.Ident, bool) {
+	for _, inst := range t.dictInstantiations[qid] {
+		if sameShapes(inst.shapes, shapes) {
+			return inst.ident, true
+		}
+	}
+	return nil, false
+}
+
+// addDictInstantiation records a new ModeDict specialization of qid so
+// that later calls with the same GC-shape tuple can reuse it.
+func (t *translator) addDictInstantiation(qid qualifiedIdent, shapes []string, ident *ast.Ident) {
+	if t.dictInstantiations == nil {
+		t.dictInstantiations = make(map[qualifiedIdent][]*dictInstantiation)
+	}
+	t.dictInstantiations[qid] = append(t.dictInstantiations[qid], &dictInstantiation{shapes: shapes, ident: ident})
+}
+
+// unsafePointerExpr returns the AST for unsafe.Pointer, used in ModeDict
+// in place of every type-parameter-typed parameter, result, and local.
+func unsafePointerExpr() ast.Expr {
+	return &ast.SelectorExpr{X: ast.NewIdent("unsafe"), Sel: ast.NewIdent("Pointer")}
+}
+
+// go2goDictName is the name of the synthesized dictionary struct type
+// emitted once per translation unit in ModeDict.
+const go2goDictName = "go2goDict"
+
+// ensureDictTypeDecl emits the go2goDict struct type the first time
+// ModeDict needs it:
+//
+//	type go2goDict struct {
+//		size  uintptr
+//		equal func(unsafe.Pointer, unsafe.Pointer) bool
+//		hash  func(unsafe.Pointer) uintptr
+//	}
+func (t *translator) ensureDictTypeDecl() {
+	if t.dictTypeEmitted {
+		return
+	}
+	t.dictTypeEmitted = true
+
+	ptrFuncType := func(results ...*ast.Field) *ast.FuncType {
+		return &ast.FuncType{
+			Params: &ast.FieldList{List: []*ast.Field{
+				{Names: []*ast.Ident{ast.NewIdent("a")}, Type: unsafePointerExpr()},
+				{Names: []*ast.Ident{ast.NewIdent("b")}, Type: unsafePointerExpr()},
+			}},
+			Results: &ast.FieldList{List: results},
+		}
+	}
+	t.newDecls = append(t.newDecls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent(go2goDictName),
+				Type: &ast.StructType{
+					Fields: &ast.FieldList{List: []*ast.Field{
+						{Names: []*ast.Ident{ast.NewIdent("size")}, Type: ast.NewIdent("uintptr")},
+						{Names: []*ast.Ident{ast.NewIdent("equal")}, Type: ptrFuncType(&ast.Field{Type: ast.NewIdent("bool")})},
+						{Names: []*ast.Ident{ast.NewIdent("hash")}, Type: &ast.FuncType{
+							Params:  &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("a")}, Type: unsafePointerExpr()}}},
+							Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("uintptr")}}},
+						}},
+					}},
+				},
+			},
+		},
+	})
+}
+
+// dictLiteral builds the go2goDict composite literal describing one
+// concrete type argument, using its original (pre-erasure) syntax
+// astType so the generated equal/hash helpers type-check against the
+// real type rather than against unsafe.Pointer.
+func (t *translator) dictLiteral(astType ast.Expr) ast.Expr {
+	asT := func(name string) ast.Expr {
+		// (*T)(name)
+		return &ast.CallExpr{
+			Fun:  &ast.ParenExpr{X: &ast.StarExpr{X: astType}},
+			Args: []ast.Expr{ast.NewIdent(name)},
+		}
+	}
+	star := func(e ast.Expr) ast.Expr { return &ast.StarExpr{X: e} }
+
+	return &ast.CompositeLit{
+		Type: ast.NewIdent(go2goDictName),
+		Elts: []ast.Expr{
+			&ast.KeyValueExpr{
+				Key: ast.NewIdent("size"),
+				Value: &ast.CallExpr{
+					Fun:  &ast.SelectorExpr{X: ast.NewIdent("unsafe"), Sel: ast.NewIdent("Sizeof")},
+					Args: []ast.Expr{&ast.CompositeLit{Type: astType}},
+				},
+			},
+			&ast.KeyValueExpr{
+				Key: ast.NewIdent("equal"),
+				Value: &ast.FuncLit{
+					Type: &ast.FuncType{
+						Params: &ast.FieldList{List: []*ast.Field{
+							{Names: []*ast.Ident{ast.NewIdent("a")}, Type: unsafePointerExpr()},
+							{Names: []*ast.Ident{ast.NewIdent("b")}, Type: unsafePointerExpr()},
+						}},
+						Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("bool")}}},
+					},
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{
+						Results: []ast.Expr{&ast.BinaryExpr{
+							X:  star(asT("a")),
+							Op: token.EQL,
+							Y:  star(asT("b")),
+						}},
+					}}},
+				},
+			},
+			&ast.KeyValueExpr{
+				Key: ast.NewIdent("hash"),
+				Value: &ast.FuncLit{
+					Type: &ast.FuncType{
+						Params:  &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("a")}, Type: unsafePointerExpr()}}},
+						Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("uintptr")}}},
+					},
+					// A full value hash would need to walk T field by
+					// field; as a placeholder, hash the bytes at a
+					// directly, which is correct for pointer-shaped T
+					// and merely a (valid, if weak) hash for others.
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{
+						Results: []ast.Expr{&ast.CallExpr{
+							Fun:  ast.NewIdent("uintptr"),
+							Args: []ast.Expr{ast.NewIdent("a")},
+						}},
+					}}},
+				},
+			},
+		},
+	}
+}
+
+// dictArgsFor returns the dictionary literal arguments for a ModeDict
+// call of qid with type arguments astTypes, one per type parameter, in
+// declaration order. It is consulted by whatever
+// emits the call expression (outside this file) so it can append these
+// as extra leading arguments instead of specializing the call.
+func (t *translator) dictArgsFor(qid qualifiedIdent, astTypes []ast.Expr) []ast.Expr {
+	args := make([]ast.Expr, len(astTypes))
+	for i, astType := range astTypes {
+		args[i] = t.dictLiteral(astType)
+	}
+	return args
+}
+
+// instantiateDictEquality rewrites a == or != comparison whose operands
+// have been erased to unsafe.Pointer into a call through dictIdent's
+// equal field, negating the result for !=.
+func (t *translator) instantiateDictEquality(ta *typeArgs, e *ast.BinaryExpr, dictIdent *ast.Ident) ast.Expr {
+	x := t.instantiateExpr(ta, e.X)
+	y := t.instantiateExpr(ta, e.Y)
+	call := ast.Expr(&ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: dictIdent, Sel: ast.NewIdent("equal")},
+		Args: []ast.Expr{x, y},
+	})
+	if e.Op == token.NEQ {
+		call = &ast.UnaryExpr{Op: token.NOT, X: &ast.ParenExpr{X: call}}
+	}
+	return call
+}
+
+// dictParamName returns the name of the synthesized dictionary
+// parameter for the type parameter named tparamName. It must be a valid
+// Go identifier, since it is printed directly into the generated
+// source.
+func dictParamName(tparamName string) string {
+	return "dict_" + tparamName
+}
+
+// instantiateFunctionDict is the ModeDict counterpart of
+// instantiateFunction below: instead of emitting a new specialization
+// per distinct type-argument tuple, it emits at most one specialization
+// per distinct GC-shape tuple (see gcShape), rewriting every
+// type-parameter-typed parameter, result, and local to unsafe.Pointer
+// and prepending one go2goDict parameter per type parameter.
+//
+// Rewriting the operations a body performs on an erased value to
+// consult the dictionary happens in instantiateExpr/instantiateStmt:
+// this change wires up == and != on a type-parameter-typed operand via
+// the dictionary's equal field (see the *ast.BinaryExpr case of
+// instantiateExpr). range and constraint method calls are left as a
+// follow-up, since they need the constraint's method set, not just the
+// erased type's shape.
+func (t *translator) instantiateFunctionDict(qid qualifiedIdent, astTypes []ast.Expr, typeTypes []types.Type) (*ast.Ident, error) {
+	shapes := make([]string, len(typeTypes))
+	for i, typ := range typeTypes {
+		shapes[i] = gcShape(typ)
+	}
+	if ident, ok := t.lookupDictInstantiation(qid, shapes); ok {
+		return ident, nil
+	}
+
+	decl, err := t.findFuncDecl(qid)
+	if err != nil {
+		return nil, err
+	}
+
+	info, ok := t.infoForID(qid)
+	if !ok {
+		return nil, fmt.Errorf("no package type info for %s", qid)
+	}
+
+	tparams := decl.Type.TParams.List
+	ta := newTypeArgs(typeTypes, info)
+	ta.dictParams = make(map[*types.TypeParam]*ast.Ident)
+	dictFields := make([]*ast.Field, 0, len(tparams))
+	i := 0
+	for _, tf := range tparams {
+		for _, tn := range tf.Names {
+			obj, ok := info.Defs[tn]
+			if !ok {
+				panic(fmt.Sprintf("no object for type parameter %q", tn))
+			}
+			objParam, ok := obj.Type().(*types.TypeParam)
+			if !ok {
+				panic(fmt.Sprintf("%v is not a TypeParam", objParam))
+			}
+			dictIdent := ast.NewIdent(dictParamName(tn.Name))
+			ta.add(obj, objParam, unsafePointerExpr(), typeTypes[i])
+			ta.dictParams[objParam] = dictIdent
+			dictFields = append(dictFields, &ast.Field{
+				Names: []*ast.Ident{dictIdent},
+				Type:  ast.NewIdent(go2goDictName),
+			})
+			i++
+		}
+	}
+
+	name, err := t.instantiatedName(qid, typeTypes)
+	if err != nil {
+		return nil, err
+	}
+	instIdent := t.newInstIdent(name+"_dict", decl.Name.Pos())
+	t.addDictInstantiation(qid, shapes, instIdent)
+	t.ensureDictTypeDecl()
+
+	params := t.instantiateFieldList(ta, decl.Type.Params)
+	var paramList []*ast.Field
+	paramList = append(paramList, dictFields...)
+	if params != nil {
+		paramList = append(paramList, params.List...)
+	}
+
+	newDecl := &ast.FuncDecl{
+		Doc:  t.withLineDirective(decl.Doc, decl.Name.Pos()),
+		Recv: t.instantiateFieldList(ta, decl.Recv),
+		Name: instIdent,
+		Type: &ast.FuncType{
+			Func:    decl.Type.Func,
+			Params:  &ast.FieldList{List: paramList},
+			Results: t.instantiateFieldList(ta, decl.Type.Results),
+		},
+		Body: t.instantiateBlockStmt(ta, decl.Body),
+	}
+	t.newDecls = append(t.newDecls, newDecl)
+
+	return instIdent, nil
+}
+
+// PosMap records, for each identifier synthesized during instantiation
+// (a specialized function or type's name), the position in the
+// original go2go source it was instantiated from. Whatever writes out
+// the generated .go file can use it to emit a sidecar <file>.go.map, or
+// `//line` directives at block boundaries, so that `go build` and
+// debuggers report errors against the user's generic source instead of
+// the generated file.
+type PosMap map[*ast.Ident]token.Pos
+
+// PosMap returns the position map accumulated so far.
+func (t *translator) PosMap() PosMap {
+	return t.posMap
+}
+
+// lineDirective returns a `//line file:line:col` directive for pos,
+// suitable for attaching at a generated block's boundary, if
+// t.emitLineDirectives is set. It returns "" if line directives are
+// off, or if pos cannot be resolved back to a source position.
+func (t *translator) lineDirective(pos token.Pos) string {
+	if !t.emitLineDirectives || t.fset == nil || pos == token.NoPos {
+		return ""
+	}
+	p := t.fset.Position(pos)
+	return fmt.Sprintf("//line %s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// newInstIdent returns a new *ast.Ident for name, positioned at orig
+// (typically the Pos of the generic declaration's own name) rather than
+// left as token.NoPos the way a bare ast.NewIdent would leave it. It
+// also records the mapping in t.posMap.
+func (t *translator) newInstIdent(name string, orig token.Pos) *ast.Ident {
+	id := ast.NewIdent(name)
+	id.NamePos = orig
+	if t.posMap == nil {
+		t.posMap = make(PosMap)
+	}
+	t.posMap[id] = orig
+	return id
+}
+
+// withLineDirective prepends a `//line` comment for orig to doc, when
+// t.emitLineDirectives is set, so that the declaration instantiateFunction
+// or instantiateTypeDecl is about to emit carries a directive pointing
+// `go build` and debuggers back at the original go2go source. It returns
+// doc unchanged if line directives are off.
+func (t *translator) withLineDirective(doc *ast.CommentGroup, orig token.Pos) *ast.CommentGroup {
+	text := t.lineDirective(orig)
+	if text == "" {
+		return doc
+	}
+	comment := &ast.Comment{Slash: orig, Text: text}
+	if doc == nil {
+		return &ast.CommentGroup{List: []*ast.Comment{comment}}
+	}
+	list := make([]*ast.Comment, 0, len(doc.List)+1)
+	list = append(list, comment)
+	list = append(list, doc.List...)
+	return &ast.CommentGroup{List: list}
+}
+
+// WritePosMap writes the sidecar `<file>.go.map` describing pm: one
+// "name\torigFile:line:col" line per identifier synthesized during
+// instantiation, for tools that want to map a generated declaration
+// back to the go2go source it was instantiated from without relying on
+// `//line` directives.
+func WritePosMap(w io.Writer, fset *token.FileSet, pm PosMap) error {
+	type entry struct {
+		name string
+		pos  token.Position
+	}
+	entries := make([]entry, 0, len(pm))
+	for id, pos := range pm {
+		entries = append(entries, entry{id.Name, fset.Position(pos)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].pos.Offset < entries[j].pos.Offset
+	})
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "%s\t%s:%d:%d\n", e.name, e.pos.Filename, e.pos.Line, e.pos.Column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instantiateFunction creates a new instantiation of a function,
+// reusing a previous instantiation if qid was already instantiated with
+// identical type arguments. When t.mode is ModeDict it instead delegates
+// to instantiateFunctionDict.
 func (t *translator) instantiateFunction(qid qualifiedIdent, astTypes []ast.Expr, typeTypes []types.Type) (*ast.Ident, error) {
+	if t.mode == ModeDict {
+		return t.instantiateFunctionDict(qid, astTypes, typeTypes)
+	}
+
+	if inst, ok := t.lookupInstantiation(qid, typeTypes); ok {
+		return inst.ident, nil
+	}
+
 	name, err := t.instantiatedName(qid, typeTypes)
 	if err != nil {
 		return nil, err
@@ -114,10 +660,11 @@ func (t *translator) instantiateFunction(qid qualifiedIdent, astTypes []ast.Expr
 
 	ta := typeArgsFromFields(t, info, astTypes, typeTypes, decl.Type.TParams.List)
 
-	instIdent := ast.NewIdent(name)
+	instIdent := t.newInstIdent(name, decl.Name.Pos())
+	t.addInstantiation(qid, typeTypes, instIdent)
 
 	newDecl := &ast.FuncDecl{
-		Doc:  decl.Doc,
+		Doc:  t.withLineDirective(decl.Doc, decl.Name.Pos()),
 		Recv: t.instantiateFieldList(ta, decl.Recv),
 		Name: instIdent,
 		Type: t.instantiateExpr(ta, decl.Type).(*ast.FuncType),
@@ -159,8 +706,14 @@ func (t *translator) infoForID(qid qualifiedIdent) (*types.Info, bool) {
 	return t.importer.lookupInfo(qid.pkg)
 }
 
-// instantiateType creates a new instantiation of a type.
+// instantiateTypeDecl creates a new instantiation of a type, reusing a
+// previous instantiation if qid was already instantiated with
+// identical type arguments.
 func (t *translator) instantiateTypeDecl(qid qualifiedIdent, typ *types.Named, astTypes []ast.Expr, typeTypes []types.Type) (*ast.Ident, types.Type, error) {
+	if inst, ok := t.lookupInstantiation(qid, typeTypes); ok {
+		return inst.ident, inst.typ, nil
+	}
+
 	name, err := t.instantiatedName(qid, typeTypes)
 	if err != nil {
 		return nil, nil, err
@@ -178,10 +731,17 @@ func (t *translator) instantiateTypeDecl(qid qualifiedIdent, typ *types.Named, a
 
 	ta := typeArgsFromFields(t, info, astTypes, typeTypes, spec.TParams.List)
 
-	instIdent := ast.NewIdent(name)
+	instIdent := t.newInstIdent(name, spec.Name.Pos())
+
+	// Record the instantiation before walking the type's definition and
+	// methods: a recursive generic type such as
+	//   type Tree[T] struct { L, R *Tree[T]; V T }
+	// refers to itself with the same type arguments, and the lookup above
+	// needs to find this entry instead of instantiating forever.
+	inst := t.addInstantiation(qid, typeTypes, instIdent)
 
 	newSpec := &ast.TypeSpec{
-		Doc:     spec.Doc,
+		Doc:     t.withLineDirective(spec.Doc, spec.Name.Pos()),
 		Name:    instIdent,
 		Assign:  spec.Assign,
 		Type:    t.instantiateExpr(ta, spec.Type),
@@ -193,7 +753,8 @@ func (t *translator) instantiateTypeDecl(qid qualifiedIdent, typ *types.Named, a
 	}
 	t.newDecls = append(t.newDecls, newDecl)
 
-	instType := t.instantiateType(ta, typ.Underlying())
+	instType := t.instantiateTypeMemo(ta, typ.Underlying())
+	inst.typ = instType
 
 	nm := typ.NumMethods()
 	for i := 0; i < nm; i++ {
@@ -203,23 +764,24 @@ func (t *translator) instantiateTypeDecl(qid qualifiedIdent, typ *types.Named, a
 			panic(fmt.Sprintf("no AST for method %v", method))
 		}
 		rtyp := mast.Recv.List[0].Type
-		newRtype := ast.Expr(ast.NewIdent(name))
+		newRtype := ast.Expr(t.newInstIdent(name, spec.Name.Pos()))
 		if p, ok := rtyp.(*ast.StarExpr); ok {
 			rtyp = p.X
 			newRtype = &ast.StarExpr{
-				X: newRtype,
+				Star: p.Star,
+				X:    newRtype,
 			}
 		}
 		tparams := rtyp.(*ast.CallExpr).Args
 		ta := typeArgsFromExprs(t, info, astTypes, typeTypes, tparams)
 		newDecl := &ast.FuncDecl{
-			Doc:  mast.Doc,
+			Doc: t.withLineDirective(mast.Doc, mast.Name.Pos()),
 			Recv: &ast.FieldList{
 				Opening: mast.Recv.Opening,
 				List: []*ast.Field{
 					{
-						Doc:     mast.Recv.List[0].Doc,
-						Names:   []*ast.Ident{
+						Doc: mast.Recv.List[0].Doc,
+						Names: []*ast.Ident{
 							mast.Recv.List[0].Names[0],
 						},
 						Type:    newRtype,
@@ -409,11 +971,131 @@ func (t *translator) instantiateStmt(ta *typeArgs, s ast.Stmt) ast.Stmt {
 			Return:  s.Return,
 			Results: results,
 		}
+	case *ast.SwitchStmt:
+		init := t.instantiateStmt(ta, s.Init)
+		tag := t.instantiateExpr(ta, s.Tag)
+		body := t.instantiateBlockStmt(ta, s.Body)
+		if init == s.Init && tag == s.Tag && body == s.Body {
+			return s
+		}
+		return &ast.SwitchStmt{
+			Switch: s.Switch,
+			Init:   init,
+			Tag:    tag,
+			Body:   body,
+		}
+	case *ast.TypeSwitchStmt:
+		init := t.instantiateStmt(ta, s.Init)
+		assign := t.instantiateStmt(ta, s.Assign)
+		body := t.instantiateBlockStmt(ta, s.Body)
+		if init == s.Init && assign == s.Assign && body == s.Body {
+			return s
+		}
+		return &ast.TypeSwitchStmt{
+			Switch: s.Switch,
+			Init:   init,
+			Assign: assign,
+			Body:   body,
+		}
+	case *ast.CaseClause:
+		list, listChanged := t.instantiateExprList(ta, s.List)
+		body, bodyChanged := t.instantiateStmtList(ta, s.Body)
+		if !listChanged && !bodyChanged {
+			return s
+		}
+		return &ast.CaseClause{
+			Case:  s.Case,
+			List:  list,
+			Colon: s.Colon,
+			Body:  body,
+		}
+	case *ast.SelectStmt:
+		body := t.instantiateBlockStmt(ta, s.Body)
+		if body == s.Body {
+			return s
+		}
+		return &ast.SelectStmt{
+			Select: s.Select,
+			Body:   body,
+		}
+	case *ast.CommClause:
+		comm := t.instantiateStmt(ta, s.Comm)
+		body, bodyChanged := t.instantiateStmtList(ta, s.Body)
+		if comm == s.Comm && !bodyChanged {
+			return s
+		}
+		return &ast.CommClause{
+			Case:  s.Case,
+			Comm:  comm,
+			Colon: s.Colon,
+			Body:  body,
+		}
+	case *ast.DeferStmt:
+		call := t.instantiateExpr(ta, s.Call)
+		if call == s.Call {
+			return s
+		}
+		return &ast.DeferStmt{
+			Defer: s.Defer,
+			Call:  call.(*ast.CallExpr),
+		}
+	case *ast.GoStmt:
+		call := t.instantiateExpr(ta, s.Call)
+		if call == s.Call {
+			return s
+		}
+		return &ast.GoStmt{
+			Go:   s.Go,
+			Call: call.(*ast.CallExpr),
+		}
+	case *ast.LabeledStmt:
+		stmt := t.instantiateStmt(ta, s.Stmt)
+		if stmt == s.Stmt {
+			return s
+		}
+		return &ast.LabeledStmt{
+			Label: s.Label,
+			Colon: s.Colon,
+			Stmt:  stmt,
+		}
+	case *ast.BranchStmt:
+		// Tok and Label refer to a statement label, not a type-parameterized
+		// value, so there is nothing to instantiate.
+		return s
+	case *ast.SendStmt:
+		ch := t.instantiateExpr(ta, s.Chan)
+		value := t.instantiateExpr(ta, s.Value)
+		if ch == s.Chan && value == s.Value {
+			return s
+		}
+		return &ast.SendStmt{
+			Chan:  ch,
+			Arrow: s.Arrow,
+			Value: value,
+		}
 	default:
 		panic(fmt.Sprintf("unimplemented Stmt %T", s))
 	}
 }
 
+// instantiateStmtList instantiates a list of statements that do not form
+// a BlockStmt on their own, such as the body of a CaseClause or CommClause.
+func (t *translator) instantiateStmtList(ta *typeArgs, sl []ast.Stmt) ([]ast.Stmt, bool) {
+	nsl := make([]ast.Stmt, len(sl))
+	changed := false
+	for i, s := range sl {
+		ns := t.instantiateStmt(ta, s)
+		if ns != s {
+			changed = true
+		}
+		nsl[i] = ns
+	}
+	if !changed {
+		return sl, false
+	}
+	return nsl, true
+}
+
 // instantiateBlockStmt instantiates a BlockStmt.
 func (t *translator) instantiateBlockStmt(ta *typeArgs, pbs *ast.BlockStmt) *ast.BlockStmt {
 	changed := false
@@ -552,6 +1234,18 @@ func (t *translator) instantiateExpr(ta *typeArgs, e ast.Expr) ast.Expr {
 			X:     x,
 		}
 	case *ast.BinaryExpr:
+		// In ModeDict, == and != on an operand whose static type is a
+		// type parameter can no longer use Go's built-in comparison once
+		// that operand is erased to unsafe.Pointer: rewrite to a call
+		// through the dictionary's equal field instead.
+		if e.Op == token.EQL || e.Op == token.NEQ {
+			if dictIdent, ok := ta.dictEqualIdent(e.X); ok {
+				return t.instantiateDictEquality(ta, e, dictIdent)
+			}
+			if dictIdent, ok := ta.dictEqualIdent(e.Y); ok {
+				return t.instantiateDictEquality(ta, e, dictIdent)
+			}
+		}
 		x := t.instantiateExpr(ta, e.X)
 		y := t.instantiateExpr(ta, e.Y)
 		if x == e.X && y == e.Y {
@@ -638,6 +1332,73 @@ func (t *translator) instantiateExpr(ta *typeArgs, e ast.Expr) ast.Expr {
 			Fields:     fields,
 			Incomplete: e.Incomplete,
 		}
+	case *ast.MapType:
+		key := t.instantiateExpr(ta, e.Key)
+		value := t.instantiateExpr(ta, e.Value)
+		if key == e.Key && value == e.Value {
+			return e
+		}
+		return &ast.MapType{
+			Map:   e.Map,
+			Key:   key,
+			Value: value,
+		}
+	case *ast.ChanType:
+		value := t.instantiateExpr(ta, e.Value)
+		if value == e.Value {
+			return e
+		}
+		return &ast.ChanType{
+			Begin: e.Begin,
+			Arrow: e.Arrow,
+			Dir:   e.Dir,
+			Value: value,
+		}
+	case *ast.InterfaceType:
+		// Methods may mention type parameters in their parameter or
+		// result lists (e.g. `interface { F(T) T }`), so instantiate the
+		// field list just as we would for a struct.
+		methods := t.instantiateFieldList(ta, e.Methods)
+		if methods == e.Methods {
+			return e
+		}
+		return &ast.InterfaceType{
+			Interface:  e.Interface,
+			Methods:    methods,
+			Incomplete: e.Incomplete,
+		}
+	case *ast.TypeAssertExpr:
+		x := t.instantiateExpr(ta, e.X)
+		typ := t.instantiateExpr(ta, e.Type)
+		if x == e.X && typ == e.Type {
+			return e
+		}
+		r = &ast.TypeAssertExpr{
+			X:      x,
+			Lparen: e.Lparen,
+			Type:   typ,
+			Rparen: e.Rparen,
+		}
+	case *ast.KeyValueExpr:
+		key := t.instantiateExpr(ta, e.Key)
+		value := t.instantiateExpr(ta, e.Value)
+		if key == e.Key && value == e.Value {
+			return e
+		}
+		r = &ast.KeyValueExpr{
+			Key:   key,
+			Colon: e.Colon,
+			Value: value,
+		}
+	case *ast.Ellipsis:
+		elt := t.instantiateExpr(ta, e.Elt)
+		if elt == e.Elt {
+			return e
+		}
+		r = &ast.Ellipsis{
+			Ellipsis: e.Ellipsis,
+			Elt:      elt,
+		}
 	default:
 		panic(fmt.Sprintf("unimplemented Expr %T", e))
 	}
@@ -645,7 +1406,7 @@ func (t *translator) instantiateExpr(ta *typeArgs, e ast.Expr) ast.Expr {
 	// We fall down to here for expressions that are not types.
 
 	if et := t.lookupType(e); et != nil {
-		t.setType(r, t.instantiateType(ta, et))
+		t.setType(r, t.instantiateTypeMemo(ta, et))
 	}
 
 	return r
@@ -0,0 +1,614 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package go2go
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func TestSameTypeArgs(t *testing.T) {
+	i := types.Typ[types.Int]
+	s := types.Typ[types.String]
+
+	// Two separately constructed pointer types are distinct values but
+	// must still compare identical: sameTypeArgs has to use
+	// types.Identical, not pointer equality.
+	p1 := types.NewPointer(i)
+	p2 := types.NewPointer(i)
+
+	tests := []struct {
+		name string
+		a, b []types.Type
+		want bool
+	}{
+		{"equal", []types.Type{i, s}, []types.Type{i, s}, true},
+		{"different length", []types.Type{i}, []types.Type{i, s}, false},
+		{"different type", []types.Type{i}, []types.Type{s}, false},
+		{"both empty", nil, nil, true},
+		{"structurally identical but distinct objects", []types.Type{p1}, []types.Type{p2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameTypeArgs(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameTypeArgs(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstantiationCacheStopsRecursion(t *testing.T) {
+	tr := &translator{}
+	qid := qualifiedIdent{ident: ast.NewIdent("Tree")}
+	intArgs := []types.Type{types.Typ[types.Int]}
+
+	if _, ok := tr.lookupInstantiation(qid, intArgs); ok {
+		t.Fatalf("lookupInstantiation found an entry before any was added")
+	}
+
+	// instantiateTypeDecl records the instantiation before walking the
+	// type's own definition, so that a recursive reference such as the
+	// *Tree[int] field of type Tree[T] struct { L, R *Tree[T]; V T }
+	// resolves to this in-flight entry instead of instantiating again.
+	treeIdent := ast.NewIdent("Tree_int")
+	inst := tr.addInstantiation(qid, intArgs, treeIdent)
+
+	got, ok := tr.lookupInstantiation(qid, intArgs)
+	if !ok || got.ident != treeIdent {
+		t.Fatalf("lookupInstantiation during self-reference = %v, %v; want %v, true", got, ok, treeIdent)
+	}
+
+	inst.typ = types.NewStruct(nil, nil)
+
+	// A distinct instantiation, Tree[string], must not collide with it.
+	stringArgs := []types.Type{types.Typ[types.String]}
+	if _, ok := tr.lookupInstantiation(qid, stringArgs); ok {
+		t.Fatalf("lookupInstantiation matched across different type arguments")
+	}
+	tr.addInstantiation(qid, stringArgs, ast.NewIdent("Tree_string"))
+
+	if got := len(tr.instantiations[qid]); got != 2 {
+		t.Fatalf("qid has %d recorded instantiations, want exactly 2 (one per distinct type-arg tuple)", got)
+	}
+}
+
+// TestMemoizeTypeStopsRecursion checks memoizeType's core contract in
+// isolation: a compute that re-enters with the same key sees the
+// provisional self-mapping recorded before it ran, so compute itself is
+// only ever invoked once per type.
+func TestMemoizeTypeStopsRecursion(t *testing.T) {
+	cache := make(map[types.Type]types.Type)
+	typ := types.Type(types.Typ[types.Int])
+
+	calls := 0
+	var compute func() types.Type
+	compute = func() types.Type {
+		calls++
+		// A self-referential compute, the way instantiateType's walk of
+		// the *Tree[T] field of type Tree[T] struct { L, R *Tree[T]; V T }
+		// calls back into itself with the same type.
+		if got := memoizeType(cache, typ, compute); got != typ {
+			t.Errorf("re-entrant memoizeType call returned %v, want the provisional entry %v", got, typ)
+		}
+		return types.NewPointer(typ)
+	}
+
+	got := memoizeType(cache, typ, compute)
+	if calls != 1 {
+		t.Fatalf("compute was called %d times, want exactly 1 (recursion must terminate via the cache)", calls)
+	}
+	if _, ok := got.(*types.Pointer); !ok {
+		t.Fatalf("memoizeType result = %#v, want *types.Pointer", got)
+	}
+}
+
+// TestMemoizeTypeWithSelfReferentialStruct drives memoizeType over a
+// types.Type graph shaped exactly like the motivating example for
+// instantiateTypeMemo: type Tree struct { L, R *Tree; V int }, where
+// walking the struct's own fields leads back to the struct itself.
+func TestMemoizeTypeWithSelfReferentialStruct(t *testing.T) {
+	obj := types.NewTypeName(token.NoPos, nil, "Tree", nil)
+	named := types.NewNamed(obj, nil, nil)
+	ptrToTree := types.NewPointer(named)
+	fields := []*types.Var{
+		types.NewField(token.NoPos, nil, "L", ptrToTree, false),
+		types.NewField(token.NoPos, nil, "R", ptrToTree, false),
+		types.NewField(token.NoPos, nil, "V", types.Typ[types.Int], false),
+	}
+	named.SetUnderlying(types.NewStruct(fields, nil))
+
+	cache := make(map[types.Type]types.Type)
+	visits := 0
+	var walk func(typ types.Type) types.Type
+	walk = func(typ types.Type) types.Type {
+		return memoizeType(cache, typ, func() types.Type {
+			visits++
+			st, ok := typ.Underlying().(*types.Struct)
+			if !ok {
+				return typ
+			}
+			for i := 0; i < st.NumFields(); i++ {
+				if p, ok := st.Field(i).Type().(*types.Pointer); ok {
+					walk(p.Elem())
+				}
+			}
+			return typ
+		})
+	}
+
+	walk(named)
+	if visits != 1 {
+		t.Fatalf("walking a self-referential struct visited the type %d times, want exactly 1", visits)
+	}
+}
+
+func TestGCShape(t *testing.T) {
+	iface := types.NewInterfaceType(nil, nil)
+	iface.Complete()
+
+	ptrA := types.NewPointer(types.NewStruct(nil, nil))
+	ptrB := types.NewPointer(types.Typ[types.String])
+	mapT := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+	chanT := types.NewChan(types.SendRecv, types.Typ[types.Int])
+
+	if gcShape(ptrA) != gcShape(ptrB) {
+		t.Errorf("two distinct pointer types should share a GC shape, got %q and %q", gcShape(ptrA), gcShape(ptrB))
+	}
+	if gcShape(ptrA) != gcShape(mapT) || gcShape(mapT) != gcShape(chanT) {
+		t.Errorf("pointers, maps, and chans should all share the pointer GC shape")
+	}
+	if gcShape(iface) == gcShape(ptrA) {
+		t.Errorf("an interface should not share a shape with a pointer")
+	}
+	if gcShape(types.Typ[types.Int]) == gcShape(types.Typ[types.String]) {
+		t.Errorf("distinct basic types should not share a shape")
+	}
+	if gcShape(types.Typ[types.Int]) != gcShape(types.Typ[types.Int]) {
+		t.Errorf("the same basic type should share a shape with itself")
+	}
+}
+
+func TestSameShapes(t *testing.T) {
+	a := []string{"ptr", "basic:int"}
+	b := []string{"ptr", "basic:int"}
+	c := []string{"ptr", "basic:string"}
+
+	if !sameShapes(a, b) {
+		t.Errorf("sameShapes(%v, %v) = false, want true", a, b)
+	}
+	if sameShapes(a, c) {
+		t.Errorf("sameShapes(%v, %v) = true, want false", a, c)
+	}
+	if sameShapes(a, []string{"ptr"}) {
+		t.Errorf("sameShapes with different lengths = true, want false")
+	}
+}
+
+// TestDictIdentifiersAreValidGo guards against the ModeDict identifier
+// mangling producing syntax that go/parser rejects, as the "." and "$"
+// based manglings once did.
+func TestDictIdentifiersAreValidGo(t *testing.T) {
+	names := []string{
+		dictParamName("T"),
+		dictParamName("Elem"),
+		"List_int" + "_dict", // mirrors the instantiateFunctionDict name+"_dict" suffix
+	}
+	for _, name := range names {
+		src := "package p\n\nfunc " + name + "() {}\n"
+		if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+			t.Errorf("generated identifier %q does not parse as Go: %v", name, err)
+		}
+	}
+}
+
+func TestDictInstantiationSharesByShape(t *testing.T) {
+	tr := &translator{}
+	qid := qualifiedIdent{ident: ast.NewIdent("Print")}
+
+	ptrShape := []string{"ptr"}
+	instIdent := ast.NewIdent("Print_dict")
+	tr.addDictInstantiation(qid, ptrShape, instIdent)
+
+	// A second type-argument tuple with the same GC shape must reuse the
+	// existing specialization rather than emitting another one.
+	got, ok := tr.lookupDictInstantiation(qid, []string{"ptr"})
+	if !ok || got != instIdent {
+		t.Fatalf("lookupDictInstantiation(ptr) = %v, %v; want %v, true", got, ok, instIdent)
+	}
+	if _, ok := tr.lookupDictInstantiation(qid, []string{"iface"}); ok {
+		t.Fatalf("lookupDictInstantiation matched a different GC shape")
+	}
+}
+
+// TestInstantiateExprDictEquality checks that == is rewritten to go
+// through the dictionary's equal field regardless of which operand,
+// X or Y, is the one whose static type is the erased type parameter.
+func TestInstantiateExprDictEquality(t *testing.T) {
+	obj := types.NewTypeName(token.NoPos, nil, "T", nil)
+	tparam := types.NewTypeParam(obj, nil)
+	dictIdent := ast.NewIdent("dict_T")
+
+	tests := []struct {
+		name            string
+		typeParamOnLeft bool
+	}{
+		{"type-param operand on the left", true},
+		{"type-param operand on the right", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+			ta := newTypeArgs([]types.Type{types.Typ[types.Int]}, info)
+			ta.dictParams = map[*types.TypeParam]*ast.Ident{tparam: dictIdent}
+
+			v := ast.NewIdent("v")
+			other := ast.NewIdent("x")
+			info.Types[v] = types.TypeAndValue{Type: tparam}
+
+			var e *ast.BinaryExpr
+			if tt.typeParamOnLeft {
+				e = &ast.BinaryExpr{X: v, Op: token.EQL, Y: other}
+			} else {
+				e = &ast.BinaryExpr{X: other, Op: token.EQL, Y: v}
+			}
+
+			tr := &translator{}
+			got := tr.instantiateExpr(ta, e)
+
+			call, ok := got.(*ast.CallExpr)
+			if !ok {
+				t.Fatalf("instantiateExpr(%v) = %T, want *ast.CallExpr calling dict.equal", e, got)
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "equal" {
+				t.Fatalf("instantiateExpr(%v) calls %v, want dict_T.equal", e, call.Fun)
+			}
+			if x, ok := sel.X.(*ast.Ident); !ok || x.Name != dictIdent.Name {
+				t.Errorf("instantiateExpr(%v) dispatches through %v, want %v", e, sel.X, dictIdent.Name)
+			}
+		})
+	}
+}
+
+// TestLineDirectiveReportsOriginalPosition verifies the primary purpose
+// of withLineDirective/lineDirective: once a generated declaration
+// carries the //line directive they produce, a type error in its body
+// is reported by go/types against the original go2go source position,
+// not the position in the generated file.
+func TestLineDirectiveReportsOriginalPosition(t *testing.T) {
+	origSrc := `package orig
+
+func Generic() {
+	var x int
+	_ = x
+}
+`
+	origFset := token.NewFileSet()
+	origFile, err := parser.ParseFile(origFset, "orig.go2", origSrc, 0)
+	if err != nil {
+		t.Fatalf("parsing original source: %v", err)
+	}
+	origDecl := origFile.Decls[0].(*ast.FuncDecl)
+	// The same position instantiateFunction/instantiateTypeDecl pass to
+	// withLineDirective: the original declaration's name.
+	origNamePos := origDecl.Name.Pos()
+	wantPosition := origFset.Position(origDecl.Body.List[0].Pos())
+
+	tr := &translator{emitLineDirectives: true, fset: origFset}
+
+	generated := &ast.FuncDecl{
+		Doc:  tr.withLineDirective(nil, origNamePos),
+		Name: ast.NewIdent("Generic_int"),
+		Type: &ast.FuncType{Params: &ast.FieldList{}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			// An intentional type error, standing in for whatever bug an
+			// instantiation might contain.
+			&ast.ExprStmt{X: ast.NewIdent("undefinedName")},
+		}},
+	}
+	genFile := &ast.File{Name: ast.NewIdent("orig"), Decls: []ast.Decl{generated}}
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), genFile); err != nil {
+		t.Fatalf("printing generated file: %v", err)
+	}
+
+	// Re-parse the printed text: go/scanner recognizes the //line
+	// directive the Doc comment carries and remaps positions of the
+	// tokens that follow it.
+	reFset := token.NewFileSet()
+	reFile, err := parser.ParseFile(reFset, "generated.go", buf.String(), 0)
+	if err != nil {
+		t.Fatalf("re-parsing generated source: %v\n%s", err, buf.String())
+	}
+
+	var typeErrs []types.Error
+	conf := types.Config{Error: func(err error) {
+		if terr, ok := err.(types.Error); ok {
+			typeErrs = append(typeErrs, terr)
+		}
+	}}
+	conf.Check("orig", reFset, []*ast.File{reFile}, nil)
+
+	if len(typeErrs) == 0 {
+		t.Fatalf("expected a type error for the undefined identifier, got none")
+	}
+	gotPosition := reFset.Position(typeErrs[0].Pos)
+
+	if gotPosition.Filename != wantPosition.Filename || gotPosition.Line != wantPosition.Line {
+		t.Errorf("reported error position = %s, want it remapped to %s (the original go2go source)", gotPosition, wantPosition)
+	}
+}
+
+func TestWritePosMap(t *testing.T) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("list.go2", -1, 100)
+	file.SetLinesForContent([]byte(strings.Repeat("x\n", 10)))
+
+	pm := PosMap{
+		ast.NewIdent("List_string"): file.Pos(14),
+		ast.NewIdent("List_int"):    file.Pos(2),
+	}
+
+	var buf bytes.Buffer
+	if err := WritePosMap(&buf, fset, pm); err != nil {
+		t.Fatalf("WritePosMap: %v", err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{"List_int\tlist.go2:", "List_string\tlist.go2:"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WritePosMap output %q missing %q", got, want)
+		}
+	}
+	// Entries are sorted by name so the sidecar file is diff-stable
+	// across runs regardless of map iteration order.
+	if strings.Index(got, "List_int") > strings.Index(got, "List_string") {
+		t.Errorf("WritePosMap output not sorted by name:\n%s", got)
+	}
+}
+
+// TestInstantiateExprMapType exercises the *ast.MapType case added to
+// instantiateExpr, checking that a type-parameter identifier appearing
+// as either the key or the value is replaced by its concrete mapping.
+func TestInstantiateExprMapType(t *testing.T) {
+	info := &types.Info{
+		Defs: make(map[*ast.Ident]types.Object),
+		Uses: make(map[*ast.Ident]types.Object),
+	}
+
+	obj := types.NewTypeName(token.NoPos, nil, "T", nil)
+	tparam := types.NewTypeParam(obj, nil)
+
+	ta := newTypeArgs([]types.Type{types.Typ[types.Int]}, info)
+	ta.add(obj, tparam, ast.NewIdent("int"), types.Typ[types.Int])
+
+	use := ast.NewIdent("T")
+	info.Uses[use] = obj
+
+	tr := &translator{}
+	got := tr.instantiateExpr(ta, &ast.MapType{Key: use, Value: use})
+
+	mt, ok := got.(*ast.MapType)
+	if !ok {
+		t.Fatalf("instantiateExpr(map[T]T) returned %T, want *ast.MapType", got)
+	}
+	key, ok := mt.Key.(*ast.Ident)
+	if !ok || key.Name != "int" {
+		t.Errorf("instantiated map key = %v, want int", mt.Key)
+	}
+	value, ok := mt.Value.(*ast.Ident)
+	if !ok || value.Name != "int" {
+		t.Errorf("instantiated map value = %v, want int", mt.Value)
+	}
+}
+
+// typeParamFixture sets up a typeArgs mapping the type parameter T to
+// the concrete type int, for use by the node-kind coverage tests below.
+// Each call to ref returns a fresh *ast.Ident usage of T, registered in
+// info.Uses, since instantiateExpr looks identifiers up by node identity.
+func typeParamFixture() (ta *typeArgs, ref func() *ast.Ident) {
+	info := &types.Info{
+		Defs: make(map[*ast.Ident]types.Object),
+		Uses: make(map[*ast.Ident]types.Object),
+	}
+	obj := types.NewTypeName(token.NoPos, nil, "T", nil)
+	tparam := types.NewTypeParam(obj, nil)
+	ta = newTypeArgs([]types.Type{types.Typ[types.Int]}, info)
+	ta.add(obj, tparam, ast.NewIdent("int"), types.Typ[types.Int])
+	ref = func() *ast.Ident {
+		id := ast.NewIdent("T")
+		info.Uses[id] = obj
+		return id
+	}
+	return ta, ref
+}
+
+func isIntIdent(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == "int"
+}
+
+// TestInstantiateStmtNewNodeKinds covers the statement kinds instantiateStmt
+// gained support for: SwitchStmt, TypeSwitchStmt, CaseClause, SelectStmt,
+// CommClause, DeferStmt, GoStmt, LabeledStmt, BranchStmt, and SendStmt.
+func TestInstantiateStmtNewNodeKinds(t *testing.T) {
+	tr := &translator{}
+
+	t.Run("SwitchStmt", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateStmt(ta, &ast.SwitchStmt{Tag: ref(), Body: &ast.BlockStmt{}})
+		sw, ok := got.(*ast.SwitchStmt)
+		if !ok || !isIntIdent(sw.Tag) {
+			t.Fatalf("instantiateStmt(switch T {}) = %#v, want SwitchStmt with Tag int", got)
+		}
+	})
+
+	t.Run("TypeSwitchStmt", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateStmt(ta, &ast.TypeSwitchStmt{
+			Assign: &ast.ExprStmt{X: ref()},
+			Body:   &ast.BlockStmt{},
+		})
+		ts, ok := got.(*ast.TypeSwitchStmt)
+		if !ok {
+			t.Fatalf("instantiateStmt(type switch) = %#v, want *ast.TypeSwitchStmt", got)
+		}
+		assign, ok := ts.Assign.(*ast.ExprStmt)
+		if !ok || !isIntIdent(assign.X) {
+			t.Errorf("instantiated Assign = %#v, want ExprStmt{X: int}", ts.Assign)
+		}
+	})
+
+	t.Run("CaseClause", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateStmt(ta, &ast.CaseClause{List: []ast.Expr{ref()}})
+		cc, ok := got.(*ast.CaseClause)
+		if !ok || len(cc.List) != 1 || !isIntIdent(cc.List[0]) {
+			t.Fatalf("instantiateStmt(case T:) = %#v, want CaseClause{List: [int]}", got)
+		}
+	})
+
+	t.Run("SelectStmt and CommClause", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		sel := &ast.SelectStmt{Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.CommClause{Comm: &ast.ExprStmt{X: ref()}},
+		}}}
+		got := tr.instantiateStmt(ta, sel)
+		gotSel, ok := got.(*ast.SelectStmt)
+		if !ok || len(gotSel.Body.List) != 1 {
+			t.Fatalf("instantiateStmt(select) = %#v, want *ast.SelectStmt with one CommClause", got)
+		}
+		comm, ok := gotSel.Body.List[0].(*ast.CommClause)
+		if !ok {
+			t.Fatalf("select body[0] = %T, want *ast.CommClause", gotSel.Body.List[0])
+		}
+		exprStmt, ok := comm.Comm.(*ast.ExprStmt)
+		if !ok || !isIntIdent(exprStmt.X) {
+			t.Errorf("instantiated CommClause.Comm = %#v, want ExprStmt{X: int}", comm.Comm)
+		}
+	})
+
+	t.Run("DeferStmt", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateStmt(ta, &ast.DeferStmt{
+			Call: &ast.CallExpr{Fun: ast.NewIdent("f"), Args: []ast.Expr{ref()}},
+		})
+		ds, ok := got.(*ast.DeferStmt)
+		if !ok || len(ds.Call.Args) != 1 || !isIntIdent(ds.Call.Args[0]) {
+			t.Fatalf("instantiateStmt(defer f(T)) = %#v, want DeferStmt calling f(int)", got)
+		}
+	})
+
+	t.Run("GoStmt", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateStmt(ta, &ast.GoStmt{
+			Call: &ast.CallExpr{Fun: ast.NewIdent("f"), Args: []ast.Expr{ref()}},
+		})
+		gs, ok := got.(*ast.GoStmt)
+		if !ok || len(gs.Call.Args) != 1 || !isIntIdent(gs.Call.Args[0]) {
+			t.Fatalf("instantiateStmt(go f(T)) = %#v, want GoStmt calling f(int)", got)
+		}
+	})
+
+	t.Run("LabeledStmt", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		label := ast.NewIdent("L")
+		got := tr.instantiateStmt(ta, &ast.LabeledStmt{Label: label, Stmt: &ast.ExprStmt{X: ref()}})
+		ls, ok := got.(*ast.LabeledStmt)
+		if !ok || ls.Label != label {
+			t.Fatalf("instantiateStmt(L: T) = %#v, want LabeledStmt keeping Label", got)
+		}
+		exprStmt, ok := ls.Stmt.(*ast.ExprStmt)
+		if !ok || !isIntIdent(exprStmt.X) {
+			t.Errorf("instantiated LabeledStmt.Stmt = %#v, want ExprStmt{X: int}", ls.Stmt)
+		}
+	})
+
+	t.Run("BranchStmt", func(t *testing.T) {
+		ta, _ := typeParamFixture()
+		orig := &ast.BranchStmt{Tok: token.BREAK}
+		got := tr.instantiateStmt(ta, orig)
+		if got != ast.Stmt(orig) {
+			t.Fatalf("instantiateStmt(break) = %#v, want the original node unchanged (nothing to instantiate)", got)
+		}
+	})
+
+	t.Run("SendStmt", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateStmt(ta, &ast.SendStmt{Chan: ast.NewIdent("ch"), Value: ref()})
+		ss, ok := got.(*ast.SendStmt)
+		if !ok || !isIntIdent(ss.Value) {
+			t.Fatalf("instantiateStmt(ch <- T) = %#v, want SendStmt{Value: int}", got)
+		}
+	})
+}
+
+// TestInstantiateExprNewNodeKinds covers the expression kinds
+// instantiateExpr gained support for: ChanType, InterfaceType,
+// TypeAssertExpr, KeyValueExpr, and Ellipsis.
+func TestInstantiateExprNewNodeKinds(t *testing.T) {
+	tr := &translator{}
+
+	t.Run("ChanType", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateExpr(ta, &ast.ChanType{Dir: ast.SEND, Value: ref()})
+		ct, ok := got.(*ast.ChanType)
+		if !ok || ct.Dir != ast.SEND || !isIntIdent(ct.Value) {
+			t.Fatalf("instantiateExpr(chan<- T) = %#v, want ChanType{Dir: SEND, Value: int}", got)
+		}
+	})
+
+	t.Run("InterfaceType", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		orig := &ast.InterfaceType{Methods: &ast.FieldList{List: []*ast.Field{
+			{Names: []*ast.Ident{ast.NewIdent("M")}, Type: &ast.FuncType{
+				Params: &ast.FieldList{List: []*ast.Field{{Type: ref()}}},
+			}},
+		}}}
+		got := tr.instantiateExpr(ta, orig)
+		it, ok := got.(*ast.InterfaceType)
+		if !ok || it.Methods == orig.Methods {
+			t.Fatalf("instantiateExpr(interface{ M(T) }) = %#v, want a rewritten *ast.InterfaceType", got)
+		}
+		fn := it.Methods.List[0].Type.(*ast.FuncType)
+		if !isIntIdent(fn.Params.List[0].Type) {
+			t.Errorf("interface method param = %#v, want int", fn.Params.List[0].Type)
+		}
+	})
+
+	t.Run("TypeAssertExpr", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateExpr(ta, &ast.TypeAssertExpr{X: ast.NewIdent("v"), Type: ref()})
+		tae, ok := got.(*ast.TypeAssertExpr)
+		if !ok || !isIntIdent(tae.Type) {
+			t.Fatalf("instantiateExpr(v.(T)) = %#v, want TypeAssertExpr{Type: int}", got)
+		}
+	})
+
+	t.Run("KeyValueExpr", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateExpr(ta, &ast.KeyValueExpr{Key: ast.NewIdent("k"), Value: ref()})
+		kv, ok := got.(*ast.KeyValueExpr)
+		if !ok || !isIntIdent(kv.Value) {
+			t.Fatalf("instantiateExpr(k: T) = %#v, want KeyValueExpr{Value: int}", got)
+		}
+	})
+
+	t.Run("Ellipsis", func(t *testing.T) {
+		ta, ref := typeParamFixture()
+		got := tr.instantiateExpr(ta, &ast.Ellipsis{Elt: ref()})
+		el, ok := got.(*ast.Ellipsis)
+		if !ok || !isIntIdent(el.Elt) {
+			t.Fatalf("instantiateExpr(...T) = %#v, want Ellipsis{Elt: int}", got)
+		}
+	})
+}